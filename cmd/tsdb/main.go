@@ -1,27 +1,44 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/fabxc/tsdb"
 	"github.com/fabxc/tsdb/labels"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
 	promlabels "github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/textparse"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/spf13/cobra"
 )
 
+// version and revision are set via -ldflags at build time.
+var (
+	version  = "unknown"
+	revision = "unknown"
+)
+
 func main() {
 	// Start HTTP server for pprof endpoint.
 	go http.ListenAndServe(":9999", nil)
@@ -46,6 +63,7 @@ func NewBenchCommand() *cobra.Command {
 		Short: "run benchmarks",
 	}
 	c.AddCommand(NewBenchWriteCommand())
+	c.AddCommand(NewBenchReadCommand())
 
 	return c
 }
@@ -55,11 +73,29 @@ type writeBenchmark struct {
 	cleanup    bool
 	numMetrics int
 
+	profiles         string
+	memProfileRate   int
+	blockProfileRate int
+
+	reportPath    string
+	reportFormat  string
+	stages        []stageResult
+	peakRSS       uint64
+	totalSamples  uint64
+	samplesPerSec float64
+
+	replay string
+	speed  float64
+	loop   bool
+
 	storage *tsdb.DB
 
-	cpuprof   *os.File
-	memprof   *os.File
-	blockprof *os.File
+	cpuprof    *os.File
+	memprof    *os.File
+	blockprof  *os.File
+	mutexprof  *os.File
+	threadprof *os.File
+	traceprof  *os.File
 }
 
 func NewBenchWriteCommand() *cobra.Command {
@@ -71,9 +107,27 @@ func NewBenchWriteCommand() *cobra.Command {
 	}
 	c.PersistentFlags().StringVar(&wb.outPath, "out", "benchout/", "set the output path")
 	c.PersistentFlags().IntVar(&wb.numMetrics, "metrics", 10000, "number of metrics to read")
+	c.PersistentFlags().StringVar(&wb.profiles, "profile", "cpu,heap,block", "comma-separated list of profiles to capture: cpu,heap,block,mutex,goroutine,threadcreate,trace")
+	c.PersistentFlags().IntVar(&wb.memProfileRate, "mem-profile-rate", 4096, "rate for runtime.MemProfileRate")
+	c.PersistentFlags().IntVar(&wb.blockProfileRate, "block-profile-rate", 1, "rate for runtime.SetBlockProfileRate")
+	c.PersistentFlags().StringVar(&wb.reportPath, "report", "", "write a JSON benchmark report to this path")
+	c.PersistentFlags().StringVar(&wb.reportFormat, "report-format", "text", "stdout summary format: text or json")
+	c.PersistentFlags().StringVar(&wb.replay, "replay", "", `input format of <file>: "" for a synthetic scrape of a label-set file, "tsdb-dir" to replay real samples out of an existing, complete tsdb storage directory (blocks + meta.json), or "remote-write" for a dump of snappy-framed prompb.WriteRequest messages. OPEN QUESTION: "tsdb-dir" cannot read a raw captured WAL segment directory (no blocks), since this vendored tsdb version has no standalone WAL-segment reader; replaying a raw WAL would need that reader written and verified first`)
+	c.PersistentFlags().Float64Var(&wb.speed, "speed", 0, "replay speed as a multiple of wallclock time for --replay mode (0 = as fast as possible)")
+	c.PersistentFlags().BoolVar(&wb.loop, "loop", false, "cycle the replay input indefinitely instead of stopping after one pass")
 	return c
 }
 
+// hasProfile reports whether the named profile was requested via --profile.
+func (b *writeBenchmark) hasProfile(name string) bool {
+	for _, p := range strings.Split(b.profiles, ",") {
+		if strings.TrimSpace(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *writeBenchmark) run(cmd *cobra.Command, args []string) {
 	if len(args) != 1 {
 		exitWithError(fmt.Errorf("missing file argument"))
@@ -107,47 +161,109 @@ func (b *writeBenchmark) run(cmd *cobra.Command, args []string) {
 	}
 	b.storage = st
 
-	var metrics []labels.Labels
+	var (
+		metrics []labels.Labels
+		samples []replaySample
+	)
 
-	measureTime("readData", func() {
-		f, err := os.Open(args[0])
-		if err != nil {
-			exitWithError(err)
-		}
-		defer f.Close()
+	measureTime(&b.stages, b.reportFormat == "json", "readData", func() {
+		switch b.replay {
+		case "":
+			f, err := os.Open(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+			defer f.Close()
 
-		metrics, err = readPrometheusLabels(f, b.numMetrics)
-		if err != nil {
-			exitWithError(err)
+			metrics, err = readPrometheusLabels(f, b.numMetrics)
+			if err != nil {
+				exitWithError(err)
+			}
+		case "tsdb-dir":
+			var err error
+			samples, err = readReplayTSDBDir(args[0])
+			if err != nil {
+				exitWithError(fmt.Errorf("bench: reading replay tsdb directory: %v\n", err))
+			}
+		case "remote-write":
+			var err error
+			samples, err = readReplayRemoteWrite(args[0])
+			if err != nil {
+				exitWithError(fmt.Errorf("bench: reading remote-write dump: %v\n", err))
+			}
+		default:
+			exitWithError(fmt.Errorf("bench: unknown --replay mode %q", b.replay))
 		}
 	})
 
 	defer func() {
 		reportSize(dir)
+		if b.reportPath != "" {
+			rep := writeReportDoc{
+				Version:       version,
+				Revision:      revision,
+				Stages:        b.stages,
+				TotalSamples:  b.totalSamples,
+				SamplesPerSec: b.samplesPerSec,
+				PeakRSSBytes:  atomic.LoadUint64(&b.peakRSS),
+				Disk:          buildDiskReport(dir),
+			}
+			if err := writeReport(b.reportPath, rep); err != nil {
+				exitWithError(fmt.Errorf("bench: could not write report: %v\n", err))
+			}
+		}
+		if b.reportFormat == "json" {
+			writeReport("-", writeReportDoc{
+				Version:       version,
+				Revision:      revision,
+				Stages:        b.stages,
+				TotalSamples:  b.totalSamples,
+				SamplesPerSec: b.samplesPerSec,
+				PeakRSSBytes:  atomic.LoadUint64(&b.peakRSS),
+				Disk:          buildDiskReport(dir),
+			})
+		}
 		if b.cleanup {
 			os.RemoveAll(b.outPath)
 		}
 	}()
 
+	stopRSS := make(chan struct{})
+	go monitorRSS(&b.peakRSS, stopRSS)
+
+	stopGoroutines := make(chan struct{})
+	go b.monitorGoroutines(stopGoroutines)
+
 	var total uint64
 
-	dur := measureTime("ingestScrapes", func() {
+	dur := measureTime(&b.stages, b.reportFormat == "json", "ingestScrapes", func() {
 		b.startProfiling()
-		total, err = b.ingestScrapes(metrics, 3000)
+		if b.replay == "" {
+			total, err = b.ingestScrapes(metrics, 3000)
+		} else {
+			total, err = b.ingestReplay(samples)
+		}
 		if err != nil {
 			exitWithError(err)
 		}
 	})
+	close(stopGoroutines)
+
+	b.totalSamples = total
+	b.samplesPerSec = float64(total) / dur.Seconds()
 
-	fmt.Println(" > total samples:", total)
-	fmt.Println(" > samples/sec:", float64(total)/dur.Seconds())
+	if b.reportFormat != "json" {
+		fmt.Println(" > total samples:", total)
+		fmt.Println(" > samples/sec:", b.samplesPerSec)
+	}
 
-	measureTime("stopStorage", func() {
+	measureTime(&b.stages, b.reportFormat == "json", "stopStorage", func() {
 		if err := b.storage.Close(); err != nil {
 			exitWithError(err)
 		}
 		b.stopProfiling()
 	})
+	close(stopRSS)
 }
 
 func (b *writeBenchmark) ingestScrapes(lbls []labels.Labels, scrapeCount int) (uint64, error) {
@@ -238,29 +354,311 @@ func (b *writeBenchmark) ingestScrapesShard(metrics []labels.Labels, scrapeCount
 	return total, nil
 }
 
-func (b *writeBenchmark) startProfiling() {
-	var err error
+// replaySample is a single (series, timestamp, value) tuple loaded from a
+// --replay input, as opposed to the synthetic counters ingestScrapesShard
+// fabricates.
+type replaySample struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
 
-	// Start CPU profiling.
-	b.cpuprof, err = os.Create(filepath.Join(b.outPath, "cpu.prof"))
+// openSnapshotForReadOnlyAccess hardlinks every file under dir into a fresh
+// temporary directory and returns that directory plus a cleanup func.
+//
+// This tsdb version has no read-only Open mode: opening a directory runs a
+// normal reload() pass (which deletes block directories listed as obsolete
+// Compaction.Parents left behind by a crashed/partial compaction), creates a
+// `lock` file, and starts a background compaction goroutine that can mutate
+// the directory again about a minute later. Setting a very long
+// RetentionDuration only suppresses retention-driven deletion; it does none
+// of the above. Hardlinking into a throwaway directory first means any of
+// that mutation — including file deletion, since unlinking one of two hard
+// links to the same inode leaves the other intact — lands on the copy, and
+// the caller's original fixture directory is left untouched.
+func openSnapshotForReadOnlyAccess(dir string) (snapshotDir string, cleanup func(), err error) {
+	tmp, err := ioutil.TempDir("", "tsdb_bench_snapshot")
 	if err != nil {
-		exitWithError(fmt.Errorf("bench: could not create cpu profile: %v\n", err))
+		return "", nil, err
 	}
-	pprof.StartCPUProfile(b.cpuprof)
+	cleanup = func() { os.RemoveAll(tmp) }
 
-	// Start memory profiling.
-	b.memprof, err = os.Create(filepath.Join(b.outPath, "mem.prof"))
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmp, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return os.Link(path, target)
+	})
 	if err != nil {
-		exitWithError(fmt.Errorf("bench: could not create memory profile: %v\n", err))
+		cleanup()
+		return "", nil, err
 	}
-	runtime.MemProfileRate = 4096
+	return tmp, cleanup, nil
+}
+
+// readReplayTSDBDir loads every (series, timestamp, value) tuple out of an
+// existing, complete tsdb storage directory (blocks plus meta.json, such as
+// one produced by a prior `bench write` run or by a real Prometheus server
+// using this storage engine) by reusing the same read path as `bench read`.
+//
+// This is NOT a raw captured WAL segment directory: this tsdb version has no
+// standalone WAL-segment reader, so a directory holding only WAL segments
+// (no blocks) cannot be replayed by this function. Flagging as an open
+// follow-up rather than implementing it silently: doing so for real would
+// mean hand-decoding this vendored tsdb version's WAL record format, which
+// isn't available to verify here.
+func readReplayTSDBDir(dir string) ([]replaySample, error) {
+	snapshotDir, cleanupSnapshot, err := openSnapshotForReadOnlyAccess(dir)
+	if err != nil {
+		return nil, fmt.Errorf("snapshotting %s for read-only access: %v", dir, err)
+	}
+	defer cleanupSnapshot()
+
+	st, err := tsdb.Open(snapshotDir, nil, nil, &tsdb.Options{
+		WALFlushInterval:  200 * time.Millisecond,
+		RetentionDuration: math.MaxInt64,
+		AppendableBlocks:  2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	q, err := st.Querier(0, tsdbMaxTime)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	all, err := labels.NewRegexpMatcher("__name__", ".*")
+	if err != nil {
+		return nil, err
+	}
+	ss, err := q.Select(all)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []replaySample
+	for ss.Next() {
+		s := ss.At()
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			samples = append(samples, replaySample{lset: s.Labels(), t: t, v: v})
+		}
+		if it.Err() != nil {
+			return nil, it.Err()
+		}
+	}
+	return samples, ss.Err()
+}
 
-	// Start fatal profiling.
-	b.blockprof, err = os.Create(filepath.Join(b.outPath, "block.prof"))
+// readReplayRemoteWrite decodes a file of concatenated remote-write dumps:
+// each entry is a 4-byte big-endian length prefix followed by that many
+// bytes of a snappy-compressed, marshaled prompb.WriteRequest. This is the
+// format produced by capturing a Prometheus remote_write endpoint's request
+// bodies one after another.
+func readReplayRemoteWrite(path string) ([]replaySample, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		exitWithError(fmt.Errorf("bench: could not create block profile: %v\n", err))
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		samples []replaySample
+		lenBuf  [4]byte
+	)
+	for {
+		if _, err := io.ReadFull(f, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		compressed := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(f, compressed); err != nil {
+			return nil, err
+		}
+		raw, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			return nil, err
+		}
+		var req prompb.WriteRequest
+		if err := proto.Unmarshal(raw, &req); err != nil {
+			return nil, err
+		}
+		for _, ts := range req.Timeseries {
+			lset := make(labels.Labels, 0, len(ts.Labels))
+			for _, l := range ts.Labels {
+				lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+			}
+			for _, s := range ts.Samples {
+				samples = append(samples, replaySample{lset: lset, t: s.Timestamp, v: s.Value})
+			}
+		}
+	}
+	return samples, nil
+}
+
+// ingestReplay replays real (series, timestamp, value) tuples loaded from a
+// --replay input into the storage, preserving their relative timing (scaled
+// by --speed) rather than fabricating a fixed scrape cadence.
+func (b *writeBenchmark) ingestReplay(samples []replaySample) (uint64, error) {
+	if len(samples) == 0 {
+		return 0, nil
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].t < samples[j].t })
+
+	tmin, tmax := samples[0].t, samples[len(samples)-1].t
+	span := tmax - tmin + 1
+
+	// Group samples sharing a timestamp into a single commit batch, mirroring
+	// how ingestScrapesShard commits once per scrape interval.
+	type batch struct {
+		t       int64
+		samples []replaySample
+	}
+	var batches []batch
+	for _, s := range samples {
+		if len(batches) == 0 || batches[len(batches)-1].t != s.t {
+			batches = append(batches, batch{t: s.t})
+		}
+		batches[len(batches)-1].samples = append(batches[len(batches)-1].samples, s)
+	}
+
+	refs := map[uint64]uint64{}
+	var total uint64
+	wallStart := time.Now()
+
+	for pass := uint64(0); pass == 0 || b.loop; pass++ {
+		offset := int64(pass) * span
+		for _, bat := range batches {
+			if b.speed > 0 {
+				target := time.Duration(float64(bat.t-tmin+offset) / b.speed * float64(time.Millisecond))
+				if sleep := target - time.Since(wallStart); sleep > 0 {
+					time.Sleep(sleep)
+				}
+			}
+
+			app := b.storage.Appender()
+			for _, s := range bat.samples {
+				h := s.lset.Hash()
+				ts := bat.t + offset
+
+				if ref, ok := refs[h]; ok {
+					if err := app.AddFast(ref, ts, s.v); err == nil {
+						total++
+						continue
+					}
+				}
+				ref, err := app.Add(s.lset, ts, s.v)
+				if err != nil {
+					return total, err
+				}
+				refs[h] = ref
+				total++
+			}
+			if err := app.Commit(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (b *writeBenchmark) startProfiling() {
+	var err error
+
+	if b.hasProfile("cpu") {
+		b.cpuprof, err = os.Create(filepath.Join(b.outPath, "cpu.prof"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create cpu profile: %v\n", err))
+		}
+		pprof.StartCPUProfile(b.cpuprof)
+	}
+
+	if b.hasProfile("heap") {
+		b.memprof, err = os.Create(filepath.Join(b.outPath, "mem.prof"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create memory profile: %v\n", err))
+		}
+		runtime.MemProfileRate = b.memProfileRate
+	}
+
+	if b.hasProfile("block") {
+		b.blockprof, err = os.Create(filepath.Join(b.outPath, "block.prof"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create block profile: %v\n", err))
+		}
+		runtime.SetBlockProfileRate(b.blockProfileRate)
+	}
+
+	if b.hasProfile("mutex") {
+		b.mutexprof, err = os.Create(filepath.Join(b.outPath, "mutex.prof"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create mutex profile: %v\n", err))
+		}
+		runtime.SetMutexProfileFraction(1)
+	}
+
+	if b.hasProfile("threadcreate") {
+		b.threadprof, err = os.Create(filepath.Join(b.outPath, "threadcreate.prof"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create threadcreate profile: %v\n", err))
+		}
+	}
+
+	if b.hasProfile("trace") {
+		b.traceprof, err = os.Create(filepath.Join(b.outPath, "trace.out"))
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not create trace file: %v\n", err))
+		}
+		if err := trace.Start(b.traceprof); err != nil {
+			exitWithError(fmt.Errorf("bench: could not start trace: %v\n", err))
+		}
+	}
+}
+
+// monitorGoroutines periodically overwrites goroutine.prof with the current
+// goroutine profile while ingestion is still running, so the dump captures
+// the contended appender goroutines rather than the idle process after
+// ingestion has already returned. It stops as soon as stop is closed and
+// does not take a final sample, since by then ingestion has finished.
+func (b *writeBenchmark) monitorGoroutines(stop <-chan struct{}) {
+	if !b.hasProfile("goroutine") {
+		return
+	}
+	snap := func() {
+		f, err := os.Create(filepath.Join(b.outPath, "goroutine.prof"))
+		if err != nil {
+			return
+		}
+		pprof.Lookup("goroutine").WriteTo(f, 0)
+		f.Close()
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	snap()
+	for {
+		select {
+		case <-ticker.C:
+			snap()
+		case <-stop:
+			return
+		}
 	}
-	runtime.SetBlockProfileRate(1)
 }
 
 func (b *writeBenchmark) stopProfiling() {
@@ -280,6 +678,375 @@ func (b *writeBenchmark) stopProfiling() {
 		b.blockprof = nil
 		runtime.SetBlockProfileRate(0)
 	}
+	if b.mutexprof != nil {
+		pprof.Lookup("mutex").WriteTo(b.mutexprof, 0)
+		b.mutexprof.Close()
+		b.mutexprof = nil
+		runtime.SetMutexProfileFraction(0)
+	}
+	if b.threadprof != nil {
+		pprof.Lookup("threadcreate").WriteTo(b.threadprof, 0)
+		b.threadprof.Close()
+		b.threadprof = nil
+	}
+	if b.traceprof != nil {
+		trace.Stop()
+		b.traceprof.Close()
+		b.traceprof = nil
+	}
+}
+
+type readBenchmark struct {
+	inPath      string
+	concurrency int
+	iterations  int
+	queryRange  time.Duration
+	matchers    []string
+	maxTimeFlag int64
+
+	reportPath   string
+	reportFormat string
+	stages       []stageResult
+	peakRSS      uint64
+
+	// maxt is the upper time bound queries are run against: either
+	// maxTimeFlag, or the real maximum sample timestamp found in the
+	// storage, detected once up front.
+	maxt int64
+
+	storage *tsdb.DB
+}
+
+func NewBenchReadCommand() *cobra.Command {
+	var rb readBenchmark
+	c := &cobra.Command{
+		Use:   "read <dir>",
+		Short: "run a read performance benchmark",
+		Run:   rb.run,
+	}
+	c.PersistentFlags().IntVar(&rb.concurrency, "concurrency", 1, "number of concurrent query workers")
+	c.PersistentFlags().IntVar(&rb.iterations, "iterations", 1000, "number of queries to run")
+	c.PersistentFlags().DurationVar(&rb.queryRange, "range", time.Hour, "time range for range queries")
+	c.PersistentFlags().StringArrayVar(&rb.matchers, "query", nil, `matcher template to draw queries from, e.g. {__name__=~"http_.+",job="api"} (may be repeated)`)
+	c.PersistentFlags().Int64Var(&rb.maxTimeFlag, "max-time", 0, "upper time bound (ms since epoch) for queries; 0 auto-detects the storage's real maximum sample timestamp")
+	c.PersistentFlags().StringVar(&rb.reportPath, "report", "", "write a JSON benchmark report to this path")
+	c.PersistentFlags().StringVar(&rb.reportFormat, "report-format", "text", "stdout summary format: text or json")
+	return c
+}
+
+func (b *readBenchmark) run(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		exitWithError(fmt.Errorf("missing storage directory argument"))
+	}
+	if len(b.matchers) == 0 {
+		exitWithError(fmt.Errorf("at least one --query matcher template is required"))
+	}
+	b.inPath = args[0]
+
+	tmpls := make([][]labels.Matcher, 0, len(b.matchers))
+	for _, tmpl := range b.matchers {
+		m, err := parseMatchers(tmpl)
+		if err != nil {
+			exitWithError(fmt.Errorf("parsing query %q: %v", tmpl, err))
+		}
+		tmpls = append(tmpls, m)
+	}
+
+	// Open a throwaway hardlinked snapshot rather than b.inPath directly: this
+	// tsdb version has no read-only Open mode, and Open's reload() pass can
+	// delete obsolete Compaction.Parents blocks, create a lock file, and kick
+	// off background compaction that mutates the directory further. See
+	// openSnapshotForReadOnlyAccess for why hardlinking keeps the original
+	// fixture intact.
+	snapshotDir, cleanupSnapshot, err := openSnapshotForReadOnlyAccess(b.inPath)
+	if err != nil {
+		exitWithError(fmt.Errorf("bench: snapshotting %s for read-only access: %v\n", b.inPath, err))
+	}
+	defer cleanupSnapshot()
+
+	st, err := tsdb.Open(snapshotDir, nil, nil, &tsdb.Options{
+		WALFlushInterval:  200 * time.Millisecond,
+		RetentionDuration: math.MaxInt64,
+		AppendableBlocks:  2,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+	b.storage = st
+	defer b.storage.Close()
+
+	b.maxt = b.maxTimeFlag
+	if b.maxt == 0 {
+		b.maxt, err = storageMaxTime(b.storage)
+		if err != nil {
+			exitWithError(fmt.Errorf("bench: could not determine storage max time: %v\n", err))
+		}
+		if b.maxt == 0 {
+			exitWithError(fmt.Errorf("bench: storage at %s has no samples", b.inPath))
+		}
+	}
+
+	kinds := []string{"instant", "range", "label_values"}
+
+	var (
+		wg          sync.WaitGroup
+		next        uint64
+		latencies   = make([]float64, b.iterations)
+		seriesTouch = make([]uint64, b.iterations)
+		samplesDec  = make([]uint64, b.iterations)
+		bytesRead   = make([]uint64, b.iterations)
+	)
+
+	stopRSS := make(chan struct{})
+	go monitorRSS(&b.peakRSS, stopRSS)
+
+	measureTime(&b.stages, b.reportFormat == "json", "runQueries", func() {
+		for w := 0; w < b.concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					i := atomic.AddUint64(&next, 1) - 1
+					if i >= uint64(b.iterations) {
+						return
+					}
+					matchers := tmpls[int(i)%len(tmpls)]
+					kind := kinds[int(i)%len(kinds)]
+
+					start := time.Now()
+					series, samples, bytes, err := b.runQuery(kind, matchers)
+					if err != nil {
+						fmt.Println(" err", err)
+						continue
+					}
+					latencies[i] = time.Since(start).Seconds() * 1000
+					seriesTouch[i] = series
+					samplesDec[i] = samples
+					bytesRead[i] = bytes
+				}
+			}()
+		}
+		wg.Wait()
+	})
+	close(stopRSS)
+
+	p50, p95, p99 := percentiles(latencies)
+
+	if b.reportFormat != "json" {
+		fmt.Printf(" > latency p50=%.3fms p95=%.3fms p99=%.3fms\n", p50, p95, p99)
+		fmt.Printf(" > series/query avg=%.1f\n", average(seriesTouch))
+		fmt.Printf(" > samples/query avg=%.1f\n", average(samplesDec))
+		fmt.Printf(" > bytes/query avg=%.1f\n", average(bytesRead))
+	}
+
+	rep := readReportDoc{
+		Version:            version,
+		Revision:           revision,
+		Stages:             b.stages,
+		Iterations:         uint64(b.iterations),
+		PeakRSSBytes:       atomic.LoadUint64(&b.peakRSS),
+		LatencyMsP50:       p50,
+		LatencyMsP95:       p95,
+		LatencyMsP99:       p99,
+		SeriesPerQueryAvg:  average(seriesTouch),
+		SamplesPerQueryAvg: average(samplesDec),
+		BytesPerQueryAvg:   average(bytesRead),
+	}
+	if b.reportPath != "" {
+		if err := writeReport(b.reportPath, rep); err != nil {
+			exitWithError(fmt.Errorf("bench: could not write report: %v\n", err))
+		}
+	}
+	if b.reportFormat == "json" {
+		writeReport("-", rep)
+	}
+}
+
+// readReportDoc is the --report document produced by `bench read`.
+type readReportDoc struct {
+	Version      string        `json:"version"`
+	Revision     string        `json:"revision"`
+	Stages       []stageResult `json:"stages"`
+	Iterations   uint64        `json:"iterations"`
+	PeakRSSBytes uint64        `json:"peak_rss_bytes"`
+
+	LatencyMsP50       float64 `json:"latency_ms_p50"`
+	LatencyMsP95       float64 `json:"latency_ms_p95"`
+	LatencyMsP99       float64 `json:"latency_ms_p99"`
+	SeriesPerQueryAvg  float64 `json:"series_per_query_avg"`
+	SamplesPerQueryAvg float64 `json:"samples_per_query_avg"`
+	BytesPerQueryAvg   float64 `json:"bytes_per_query_avg"`
+}
+
+// runQuery executes a single query of the given kind against the storage and
+// returns the number of series touched, samples decoded, and approximate
+// bytes read. maxt is always b.maxt, the real max timestamp present in the
+// storage, so both "instant" and "range" queries actually hit data: a
+// "range" query decodes every sample in [maxt-queryRange, maxt] per series,
+// while an "instant" query decodes that same window but only counts the
+// latest sample at-or-before maxt per series, mirroring PromQL's instant
+// vector selection with a lookback window. A "label_values" query decodes no
+// samples: its first return value is the number of label values found for
+// the matchers' label names, and bytes is their total length.
+func (b *readBenchmark) runQuery(kind string, matchers []labels.Matcher) (series, samples, bytes uint64, err error) {
+	maxt := b.maxt
+	mint := maxt - int64(b.queryRange/time.Millisecond)
+
+	q, err := b.storage.Querier(mint, maxt)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer q.Close()
+
+	if kind == "label_values" {
+		for _, m := range matchers {
+			vals, err := q.LabelValues(m.Name())
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			series += uint64(len(vals))
+			for _, v := range vals {
+				bytes += uint64(len(v))
+			}
+		}
+		return series, samples, bytes, nil
+	}
+
+	ss, err := q.Select(matchers...)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for ss.Next() {
+		series++
+		it := ss.At().Iterator()
+		var sawSample bool
+		for it.Next() {
+			sawSample = true
+			if kind == "range" {
+				samples++
+				bytes += 16 // timestamp + float64 value
+			}
+		}
+		if it.Err() != nil {
+			return series, samples, bytes, it.Err()
+		}
+		if kind == "instant" && sawSample {
+			samples++
+			bytes += 16
+		}
+	}
+	return series, samples, bytes, ss.Err()
+}
+
+// tsdbMaxTime is used as the upper time bound when scanning an entire
+// storage directory (e.g. to find its real max sample timestamp, or to
+// replay every sample out of it); it is far enough in the future to cover
+// any realistically ingested data set. It must never be used as the maxt of
+// an actual benchmark query, since real data is never ingested anywhere
+// near it and the query would always see an empty time window.
+const tsdbMaxTime = 1 << 62
+
+// storageMaxTime scans every series in the storage and returns the largest
+// sample timestamp found, so that read-benchmark queries can be run against
+// a time window that actually contains data.
+func storageMaxTime(st *tsdb.DB) (int64, error) {
+	q, err := st.Querier(0, tsdbMaxTime)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	all, err := labels.NewRegexpMatcher("__name__", ".*")
+	if err != nil {
+		return 0, err
+	}
+	ss, err := q.Select(all)
+	if err != nil {
+		return 0, err
+	}
+
+	var maxt int64
+	for ss.Next() {
+		it := ss.At().Iterator()
+		for it.Next() {
+			if t, _ := it.At(); t > maxt {
+				maxt = t
+			}
+		}
+		if it.Err() != nil {
+			return maxt, it.Err()
+		}
+	}
+	return maxt, ss.Err()
+}
+
+// parseMatchers parses a PromQL-style matcher template such as
+// `{__name__=~"http_.+",job="api"}` into a set of label matchers.
+func parseMatchers(tmpl string) ([]labels.Matcher, error) {
+	tmpl = strings.TrimSpace(tmpl)
+	tmpl = strings.TrimPrefix(tmpl, "{")
+	tmpl = strings.TrimSuffix(tmpl, "}")
+
+	if tmpl == "" {
+		return nil, fmt.Errorf("empty matcher template")
+	}
+
+	re := regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"((?:[^"\\]|\\.)*)"`)
+
+	var matchers []labels.Matcher
+	for _, part := range strings.Split(tmpl, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m := re.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid matcher %q", part)
+		}
+		name, op, value := m[1], m[2], m[3]
+
+		var lm labels.Matcher
+		var err error
+		switch op {
+		case "=":
+			lm = labels.NewEqualMatcher(name, value)
+		case "=~":
+			lm, err = labels.NewRegexpMatcher(name, value)
+		case "!=", "!~":
+			return nil, fmt.Errorf("negative matchers are not supported: %q", part)
+		}
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, lm)
+	}
+	return matchers, nil
+}
+
+func percentiles(vs []float64) (p50, p95, p99 float64) {
+	if len(vs) == 0 {
+		return 0, 0, 0
+	}
+	sorted := make([]float64, len(vs))
+	copy(sorted, vs)
+	sort.Float64s(sorted)
+
+	at := func(p float64) float64 {
+		i := int(p * float64(len(sorted)-1))
+		return sorted[i]
+	}
+	return at(0.50), at(0.95), at(0.99)
+}
+
+func average(vs []uint64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	var sum uint64
+	for _, v := range vs {
+		sum += v
+	}
+	return float64(sum) / float64(len(vs))
 }
 
 func reportSize(dir string) {
@@ -298,12 +1065,154 @@ func reportSize(dir string) {
 	}
 }
 
-func measureTime(stage string, f func()) time.Duration {
-	fmt.Printf(">> start stage=%s\n", stage)
+// writeReportDoc is the --report document produced by `bench write`.
+type writeReportDoc struct {
+	Version       string        `json:"version"`
+	Revision      string        `json:"revision"`
+	Stages        []stageResult `json:"stages"`
+	TotalSamples  uint64        `json:"total_samples"`
+	SamplesPerSec float64       `json:"samples_per_sec"`
+	PeakRSSBytes  uint64        `json:"peak_rss_bytes"`
+	Disk          diskReport    `json:"disk"`
+}
+
+// diskReport breaks down on-disk size by block and file type.
+type diskReport struct {
+	TotalBytes int64             `json:"total_bytes"`
+	WALBytes   int64             `json:"wal_bytes"`
+	Blocks     []blockDiskReport `json:"blocks"`
+}
+
+// blockDiskReport is the per-file-type disk usage of a single block.
+type blockDiskReport struct {
+	Block           string `json:"block"`
+	ChunksBytes     int64  `json:"chunks_bytes"`
+	IndexBytes      int64  `json:"index_bytes"`
+	TombstonesBytes int64  `json:"tombstones_bytes"`
+}
+
+// buildDiskReport walks a storage directory and classifies each file's size
+// by the block it belongs to (top-level subdirectories other than "wal")
+// and by file type (chunks, index, tombstones).
+func buildDiskReport(dir string) diskReport {
+	var rep diskReport
+	blocks := map[string]*blockDiskReport{}
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rep.TotalBytes += info.Size()
+
+		parts := strings.Split(rel, string(filepath.Separator))
+		if parts[0] == "wal" {
+			rep.WALBytes += info.Size()
+			return nil
+		}
+		if len(parts) == 1 {
+			// Top-level files (such as the lock file) belong to no block.
+			return nil
+		}
+
+		br, ok := blocks[parts[0]]
+		if !ok {
+			br = &blockDiskReport{Block: parts[0]}
+			blocks[parts[0]] = br
+		}
+		switch {
+		case strings.Contains(rel, "chunks"+string(filepath.Separator)):
+			br.ChunksBytes += info.Size()
+		case info.Name() == "index":
+			br.IndexBytes += info.Size()
+		case info.Name() == "tombstones":
+			br.TombstonesBytes += info.Size()
+		}
+		return nil
+	})
+
+	for _, br := range blocks {
+		rep.Blocks = append(rep.Blocks, *br)
+	}
+	sort.Slice(rep.Blocks, func(i, j int) bool { return rep.Blocks[i].Block < rep.Blocks[j].Block })
+	return rep
+}
+
+// measureTime times f, recording the stage name and duration into stages (if
+// non-nil) for later inclusion in a JSON report. It also prints a
+// human-readable start/completion line to stdout, unless quiet is set, so
+// that --report-format=json output isn't interleaved with lines a CI
+// pipeline would otherwise have to scrape around.
+func measureTime(stages *[]stageResult, quiet bool, stage string, f func()) time.Duration {
+	if !quiet {
+		fmt.Printf(">> start stage=%s\n", stage)
+	}
 	start := time.Now()
 	f()
-	fmt.Printf(">> completed stage=%s duration=%s\n", stage, time.Since(start))
-	return time.Since(start)
+	dur := time.Since(start)
+	if !quiet {
+		fmt.Printf(">> completed stage=%s duration=%s\n", stage, dur)
+	}
+	if stages != nil {
+		*stages = append(*stages, stageResult{Name: stage, Seconds: dur.Seconds()})
+	}
+	return dur
+}
+
+// stageResult records the wall-clock duration of a single named benchmark
+// stage for inclusion in a --report document.
+type stageResult struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"duration_seconds"`
+}
+
+// monitorRSS periodically samples runtime.MemStats.Sys, the closest portable
+// proxy for RSS, storing the peak value into peak until stop is closed.
+func monitorRSS(peak *uint64, stop <-chan struct{}) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	sample := func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		for {
+			old := atomic.LoadUint64(peak)
+			if ms.Sys <= old || atomic.CompareAndSwapUint64(peak, old, ms.Sys) {
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			sample()
+		case <-stop:
+			sample()
+			return
+		}
+	}
+}
+
+// writeReport marshals v as JSON to path. When path is "-" it writes a
+// compact one-line summary to stdout, suitable for CI to diff between runs;
+// otherwise it writes an indented document to the given file.
+func writeReport(path string, v interface{}) error {
+	if path == "-" {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
 }
 
 func readPrometheusLabels(r io.Reader, n int) ([]labels.Labels, error) {